@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ApiKeyAuthenticator authenticates callers presenting an `x-api-key: <key-id>.<hex-hmac>` header,
+// where hex-hmac = HMAC-SHA256(secret, key-id) for that key ID's registered secret. The proxy never
+// has to compare the caller's raw secret, only the HMAC, and the comparison is constant-time.
+type ApiKeyAuthenticator struct {
+	secrets map[string][]byte
+	scopes  map[string][]string
+}
+
+// NewApiKeyAuthenticator builds an authenticator from an already-loaded secret/scope set; use
+// LoadApiKeysFile to build these maps from a flat file.
+func NewApiKeyAuthenticator(secrets map[string][]byte, scopes map[string][]string) *ApiKeyAuthenticator {
+	return &ApiKeyAuthenticator{secrets: secrets, scopes: scopes}
+}
+
+// LoadApiKeysFile reads `key-id:hex-secret:scope1,scope2` lines (the scopes column is optional)
+// from filePath into the maps NewApiKeyAuthenticator expects.
+func LoadApiKeysFile(filePath string) (secrets map[string][]byte, scopes map[string][]string, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	secrets = map[string][]byte{}
+	scopes = map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) < 2 {
+			return nil, nil, fmt.Errorf("malformed API key line %q, want key-id:hex-secret[:scope1,scope2]", line)
+		}
+		secret, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed API key secret for %q: %v", parts[0], err)
+		}
+		secrets[parts[0]] = secret
+		if len(parts) == 3 && parts[2] != "" {
+			scopes[parts[0]] = strings.Split(parts[2], ",")
+		}
+	}
+	return secrets, scopes, scanner.Err()
+}
+
+func (a *ApiKeyAuthenticator) Authenticate(ctx context.Context, md metadata.MD) (*Identity, error) {
+	values := md.Get("x-api-key")
+	if len(values) == 0 {
+		return nil, ErrNoCredential
+	}
+	keyID, sig, ok := splitApiKey(values[0])
+	if !ok {
+		return nil, fmt.Errorf("malformed x-api-key header, want <key-id>.<hex-hmac>")
+	}
+	secret, ok := a.secrets[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown API key id %q", keyID)
+	}
+	given, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("malformed API key signature for %q", keyID)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(keyID))
+	if subtle.ConstantTimeCompare(mac.Sum(nil), given) != 1 {
+		return nil, fmt.Errorf("invalid API key signature for %q", keyID)
+	}
+	return &Identity{Subject: keyID, Scopes: a.scopes[keyID]}, nil
+}
+
+func splitApiKey(raw string) (keyID, sig string, ok bool) {
+	parts := strings.SplitN(raw, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
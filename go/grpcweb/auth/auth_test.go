@@ -0,0 +1,84 @@
+package auth
+
+import "testing"
+
+func TestPolicyRequiredScopes(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{MethodGlob: "/admin.Service/*", RequiredScopes: []string{"admin"}},
+		{MethodGlob: "/public.Service/*", RequiredScopes: nil},
+	}}
+
+	scopes, matched := policy.requiredScopes("/admin.Service/Delete")
+	if !matched || len(scopes) != 1 || scopes[0] != "admin" {
+		t.Errorf("requiredScopes(admin call) = %v, %v, want [admin], true", scopes, matched)
+	}
+
+	scopes, matched = policy.requiredScopes("/public.Service/Get")
+	if !matched || len(scopes) != 0 {
+		t.Errorf("requiredScopes(public call) = %v, %v, want [], true", scopes, matched)
+	}
+
+	if _, matched := policy.requiredScopes("/other.Service/Get"); matched {
+		t.Errorf("requiredScopes(unmatched call) should not have matched any rule")
+	}
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{MethodGlob: "/admin.Service/*", RequiredScopes: []string{"admin", "superuser"}},
+	}}
+
+	if err := policy.authorize("/admin.Service/Delete", &Identity{Subject: "alice", Scopes: []string{"admin"}}); err != nil {
+		t.Errorf("authorize() with a held required scope returned an error: %v", err)
+	}
+	if err := policy.authorize("/admin.Service/Delete", &Identity{Subject: "bob", Scopes: []string{"read"}}); err == nil {
+		t.Errorf("authorize() without any required scope should have returned an error")
+	}
+	if err := policy.authorize("/public.Service/Get", &Identity{Subject: "bob"}); err != nil {
+		t.Errorf("authorize() for a method with no matching rule should let any authenticated caller through: %v", err)
+	}
+}
+
+func TestSplitApiKey(t *testing.T) {
+	tests := []struct {
+		raw       string
+		wantKeyID string
+		wantSig   string
+		wantOK    bool
+	}{
+		{raw: "key1.deadbeef", wantKeyID: "key1", wantSig: "deadbeef", wantOK: true},
+		{raw: "key1.dead.beef", wantKeyID: "key1", wantSig: "dead.beef", wantOK: true},
+		{raw: "missing-separator", wantOK: false},
+		{raw: ".deadbeef", wantOK: false},
+		{raw: "key1.", wantOK: false},
+		{raw: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		keyID, sig, ok := splitApiKey(tt.raw)
+		if ok != tt.wantOK {
+			t.Errorf("splitApiKey(%q) ok = %v, want %v", tt.raw, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if keyID != tt.wantKeyID || sig != tt.wantSig {
+			t.Errorf("splitApiKey(%q) = (%q, %q), want (%q, %q)", tt.raw, keyID, sig, tt.wantKeyID, tt.wantSig)
+		}
+	}
+}
+
+func TestScopesFromClaim(t *testing.T) {
+	if got := scopesFromClaim("openid profile read:things"); len(got) != 3 || got[0] != "openid" || got[2] != "read:things" {
+		t.Errorf("scopesFromClaim(space-separated string) = %v", got)
+	}
+	if got := scopesFromClaim([]interface{}{"openid", "profile"}); len(got) != 2 || got[1] != "profile" {
+		t.Errorf("scopesFromClaim(JSON array) = %v", got)
+	}
+	if got := scopesFromClaim([]interface{}{"openid", 42}); len(got) != 1 || got[0] != "openid" {
+		t.Errorf("scopesFromClaim(mixed-type array) should skip non-string entries, got %v", got)
+	}
+	if got := scopesFromClaim(nil); got != nil {
+		t.Errorf("scopesFromClaim(nil) = %v, want nil", got)
+	}
+}
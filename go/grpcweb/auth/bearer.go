@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// BearerTokenAuthenticator authenticates callers presenting a static `Authorization: Bearer
+// <token>` header against a flat file of `token:subject:scope1,scope2` lines (the scopes column is
+// optional). Call Reload again, e.g. from an fsnotify watch, to pick up changes to the file.
+type BearerTokenAuthenticator struct {
+	mu     sync.RWMutex
+	tokens map[string]*Identity
+}
+
+// NewBearerTokenAuthenticator loads tokens from filePath.
+func NewBearerTokenAuthenticator(filePath string) (*BearerTokenAuthenticator, error) {
+	a := &BearerTokenAuthenticator{}
+	return a, a.Reload(filePath)
+}
+
+// Reload replaces the token set in place with the contents of filePath.
+func (a *BearerTokenAuthenticator) Reload(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tokens := map[string]*Identity{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("malformed bearer token line %q, want token:subject[:scope1,scope2]", line)
+		}
+		identity := &Identity{Subject: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			identity.Scopes = strings.Split(parts[2], ",")
+		}
+		tokens[parts[0]] = identity
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.tokens = tokens
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *BearerTokenAuthenticator) Authenticate(ctx context.Context, md metadata.MD) (*Identity, error) {
+	token := bearerToken(md)
+	if token == "" {
+		return nil, ErrNoCredential
+	}
+	a.mu.RLock()
+	identity, ok := a.tokens[token]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	return identity, nil
+}
+
+func bearerToken(md metadata.MD) string {
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(values[0], "Bearer ")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// The admin listener carries everything operational (/metrics, /debug/pprof, /debug/requests,
+// /settings) that used to be entangled with the main gRPC/gRPC-Web port, so it can be firewalled
+// off separately from client-facing traffic.
+var (
+	flagAdminBindAddress = pflag.String("admin_bind_address", "127.0.0.1", "address to bind the admin (metrics/pprof/settings) listener to.")
+	flagAdminPort        = pflag.Int("admin_port", 8081, "TCP port to listen on for the admin endpoints. 0 disables the admin listener.")
+	flagAdminTlsCert     = pflag.String("admin_tls_cert_file", "", "Path to the PEM cert file for the admin listener. Leave unset to serve admin endpoints over plain HTTP.")
+	flagAdminTlsKey      = pflag.String("admin_tls_key_file", "", "Path to the PEM key file for the admin listener, required when admin_tls_cert_file is set.")
+	flagAdminTrustedProxies = pflag.StringSlice("admin_trusted_proxies", nil, "comma-separated CIDRs allowed to reach the admin listener. Empty allows all callers.")
+
+	// flagAdminTlsClientCertVerification and flagAdminTlsClientCAFiles let the admin listener be
+	// guarded by mTLS instead of (or as well as) --admin_api_token, same verification policy as the
+	// client-facing listener in server_tls.go.
+	flagAdminTlsClientCertVerification = pflag.String("admin_tls_client_cert_verification", "none", "Client certificate verification policy for the admin listener, when admin_tls_cert_file is set. One of: none, verify_if_given, require_and_verify.")
+	flagAdminTlsClientCAFiles          = pflag.StringSlice("admin_tls_client_ca_files", nil, "Comma-separated paths to PEM files with client CAs used to verify client certificates on the admin listener, when admin_tls_client_cert_verification is not 'none'.")
+)
+
+func buildAdminServer() *http.Server {
+	http.HandleFunc("/settings", settingsHandler)
+	http.Handle("/metrics", promhttp.Handler())
+	return &http.Server{
+		WriteTimeout: *flagHttpMaxWriteTimeout,
+		ReadTimeout:  *flagHttpMaxReadTimeout,
+		Handler:      trustedProxyMiddleware(http.DefaultServeMux, *flagAdminTrustedProxies),
+	}
+}
+
+func buildAdminListenerOrFail() net.Listener {
+	addr := fmt.Sprintf("%s:%d", *flagAdminBindAddress, *flagAdminPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logrus.Fatalf("failed listening for 'admin' on %v: %v", addr, err)
+	}
+	if *flagAdminTlsCert != "" {
+		if *flagAdminTlsKey == "" {
+			logrus.Fatalf("--admin_tls_key_file must be set alongside --admin_tls_cert_file")
+		}
+		cert, err := tls.LoadX509KeyPair(*flagAdminTlsCert, *flagAdminTlsKey)
+		if err != nil {
+			logrus.Fatalf("failed reading admin TLS keys: %v", err)
+		}
+		clientAuth, err := clientCertVerificationFromFlag(*flagAdminTlsClientCertVerification)
+		if err != nil {
+			logrus.Fatalf("--admin_tls_client_cert_verification: %v", err)
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   clientAuth,
+		}
+		if clientAuth != tls.NoClientCert {
+			if len(*flagAdminTlsClientCAFiles) == 0 {
+				logrus.Fatalf("--admin_tls_client_ca_files must be set when --admin_tls_client_cert_verification is %q", *flagAdminTlsClientCertVerification)
+			}
+			tlsConfig.ClientCAs = loadCertPoolOrFail(*flagAdminTlsClientCAFiles)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener
+}
+
+// trustedProxyMiddleware rejects callers whose remote address doesn't fall within one of the
+// given CIDRs. An empty list disables the check, preserving today's open-by-default behaviour.
+func trustedProxyMiddleware(next http.Handler, trustedCIDRs []string) http.Handler {
+	if len(trustedCIDRs) == 0 {
+		return next
+	}
+	nets := make([]*net.IPNet, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logrus.Fatalf("invalid --admin_trusted_proxies entry %q: %v", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		for _, ipNet := range nets {
+			if ip != nil && ipNet.Contains(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "admin endpoint not reachable from this address", http.StatusForbidden)
+	})
+}
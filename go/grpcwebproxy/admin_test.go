@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxyMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name         string
+		trustedCIDRs []string
+		remoteAddr   string
+		wantStatus   int
+	}{
+		{name: "no CIDRs configured allows anyone", trustedCIDRs: nil, remoteAddr: "203.0.113.5:1234", wantStatus: http.StatusOK},
+		{name: "address within a trusted CIDR", trustedCIDRs: []string{"127.0.0.0/8"}, remoteAddr: "127.0.0.1:1234", wantStatus: http.StatusOK},
+		{name: "address outside every trusted CIDR", trustedCIDRs: []string{"127.0.0.0/8"}, remoteAddr: "203.0.113.5:1234", wantStatus: http.StatusForbidden},
+		{name: "remote addr with no port still parses", trustedCIDRs: []string{"127.0.0.0/8"}, remoteAddr: "127.0.0.1", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := trustedProxyMiddleware(ok, tt.trustedCIDRs)
+			req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
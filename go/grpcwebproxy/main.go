@@ -7,18 +7,17 @@ import (
 	"net/http"
 	_ "net/http/pprof" // register in DefaultServerMux
 	"os"
+	"sort"
+	"sync"
 	"time"
 	"encoding/json"
 
-	"crypto/tls"
-
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/dshuffma-ibm/grpc-web/go/grpcweb"
 	"github.com/mwitkow/go-conntrack"
 	"github.com/mwitkow/grpc-proxy/proxy"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"golang.org/x/net/context"
@@ -30,7 +29,7 @@ import (
 
 var (
 	flagBindAddr    = pflag.String("server_bind_address", "0.0.0.0", "address to bind the server to")
-	flagHttpPort    = pflag.Int("server_http_debug_port", 8080, "TCP port to listen on for HTTP1.1 debug calls.")
+	flagHttpPort    = pflag.Int("server_http_port", 8080, "TCP port to listen on for plain-HTTP1.1 gRPC-Web/websocket calls. Debug endpoints (/metrics, /debug/pprof, /debug/requests, /settings) live on --admin_port instead.")
 	flagHttpTlsPort = pflag.Int("server_http_tls_port", 8443, "TCP port to listen on for HTTPS (gRPC, gRPC-Web).")
 
 	flagAllowAllOrigins = pflag.Bool("allow_all_origins", false, "allow requests from any origin.")
@@ -43,6 +42,11 @@ var (
 
 	flagHttpMaxWriteTimeout = pflag.Duration("server_http_max_write_timeout", 10*time.Second, "HTTP server config, max write duration.")
 	flagHttpMaxReadTimeout  = pflag.Duration("server_http_max_read_timeout", 10*time.Second, "HTTP server config, max read duration.")
+
+	// liveAllowedOrigins backs the OriginFunc/WebsocketOriginFunc captured by wrappedGrpc at startup;
+	// its Set method is called from the /settings PUT handler so a reload doesn't need to rebuild
+	// the gRPC-Web wrapper.
+	liveAllowedOrigins *allowedOrigins
 )
 
 func main() {
@@ -54,58 +58,43 @@ func main() {
 	if *flagAllowAllOrigins && len(*flagAllowedOrigins) != 0 {
 		logrus.Fatal("Ambiguous --allow_all_origins and --allow_origins configuration. Either set --allow_all_origins=true OR specify one or more origins to whitelist with --allow_origins, not both.")
 	}
+	if !*runHttpServer && !*runTlsServer {
+		logrus.Fatalf("Both run_http_server and run_tls_server are set to false. At least one must be enabled for grpcweb proxy to function correctly.")
+	}
+
+	var theSettings Settings = buildSettings();
+	jsonData, _ := json.Marshal(theSettings)
+	logrus.Printf("version: %s", theSettings.Version)
+	logrus.Printf("grpc web proxy configuration settings: %s", jsonData)
 
-	grpcServer := buildGrpcProxyServer(logEntry)
+	listeners := Listen()
 	errChan := make(chan error)
+	Run(context.Background(), listeners, logEntry, errChan)
+
+	<-errChan
+}
+
+// buildProxyAndWrapper wires together the gRPC proxy server and its gRPC-Web wrapper. It's called
+// once at startup by Run(); liveAllowedOrigins is what lets the origin whitelist change afterwards
+// without calling this again.
+func buildProxyAndWrapper(logger *logrus.Entry) (*grpc.Server, *grpcweb.WrappedGrpcServer) {
+	grpcServer := buildGrpcProxyServer(logger)
 
-	allowedOrigins := makeAllowedOrigins(*flagAllowedOrigins)
+	liveAllowedOrigins = makeAllowedOrigins(*flagAllowedOrigins)
 
 	options := []grpcweb.Option{
 		grpcweb.WithCorsForRegisteredEndpointsOnly(false),
-		grpcweb.WithOriginFunc(makeHttpOriginFunc(allowedOrigins)),
+		grpcweb.WithOriginFunc(makeHttpOriginFunc(liveAllowedOrigins)),
 	}
-
 	if *useWebsockets {
 		logrus.Println("using websockets")
 		options = append(
 			options,
 			grpcweb.WithWebsockets(true),
-			grpcweb.WithWebsocketOriginFunc(makeWebsocketOriginFunc(allowedOrigins)),
+			grpcweb.WithWebsocketOriginFunc(makeWebsocketOriginFunc(liveAllowedOrigins)),
 		)
 	}
-	wrappedGrpc := grpcweb.WrapServer(grpcServer, options...)
-
-	if !*runHttpServer && !*runTlsServer {
-		logrus.Fatalf("Both run_http_server and run_tls_server are set to false. At least one must be enabled for grpcweb proxy to function correctly.")
-	}
-
-	var theSettings Settings = buildSettings();
-	jsonData, _ := json.Marshal(theSettings)
-	logrus.Printf("version: %s", theSettings.Version)
-	logrus.Printf("grpc web proxy configuration settings: %s", jsonData)
-
-	http.Handle("/", wrappedGrpc)
-	http.HandleFunc("/settings", leakSettings)
-
-	if *runHttpServer {
-		// Debug server.
-		debugServer := buildServer(http.DefaultServeMux)
-		http.Handle("/metrics", promhttp.Handler())
-		debugListener := buildListenerOrFail("http", *flagHttpPort)
-		serveServer(debugServer, debugListener, "http", errChan)
-	}
-
-	if *runTlsServer {
-		// tls server.
-		//servingServer := buildServer(wrappedGrpc)
-		servingServer := buildServer(http.DefaultServeMux)
-		servingListener := buildListenerOrFail("http", *flagHttpTlsPort)
-		servingListener = tls.NewListener(servingListener, buildServerTlsOrFail())
-		serveServer(servingServer, servingListener, "http_tls", errChan)
-	}
-
-	<-errChan
-	// TODO(mwitkow): Add graceful shutdown.
+	return grpcServer, grpcweb.WrapServer(grpcServer, options...)
 }
 
 // build the settings to print out later
@@ -138,6 +127,9 @@ type Settings struct {
 	FlagTlsServerClientCertVerification string `json:"server_tls_client_cert_verification"`
 	FlagTlsServerClientCAFiles []string `json:"server_tls_client_ca_files"`
 
+	// defined in router.go
+	FlagDirectorConfigFile string `json:"director_config_file"`
+
 	// version of the grpc web proxy, hard coded
 	Version string `json:"version"`
 }
@@ -156,8 +148,15 @@ func buildSettings() Settings{
 	theSettings.FlagBackendBackoffMaxDelay = *flagBackendBackoffMaxDelay
 
 	theSettings.FlagBindAddr = *flagBindAddr
-	theSettings.FlagAllowAllOrigins = *flagAllowAllOrigins
-	theSettings.FlagAllowedOrigins = *flagAllowedOrigins
+	// liveAllowedOrigins is nil only for the one-off startup log before Run() builds it; after
+	// that it's the authoritative, mutex-guarded copy, since applySettingsUpdate (reload.go) no
+	// longer writes back into the *flagAllowAllOrigins/*flagAllowedOrigins globals themselves.
+	if liveAllowedOrigins != nil {
+		theSettings.FlagAllowAllOrigins, theSettings.FlagAllowedOrigins = liveAllowedOrigins.Get()
+	} else {
+		theSettings.FlagAllowAllOrigins = *flagAllowAllOrigins
+		theSettings.FlagAllowedOrigins = *flagAllowedOrigins
+	}
 	theSettings.RunHTTPServer = *runHttpServer
 	theSettings.RunTLSServer = *runTlsServer
 	theSettings.UseWebSockets = *useWebsockets
@@ -168,6 +167,9 @@ func buildSettings() Settings{
 	theSettings.FlagTlsServerClientCertVerification = *flagTlsServerClientCertVerification
 	theSettings.FlagTlsServerClientCAFiles = *flagTlsServerClientCAFiles
 
+	// defined in router.go
+	theSettings.FlagDirectorConfigFile = *flagDirectorConfigFile
+
 	theSettings.Version = "v0.11.0-1"
 
 	if theSettings.ExternalAddr == "" {
@@ -211,29 +213,60 @@ func buildGrpcProxyServer(logger *logrus.Entry) *grpc.Server {
 	grpc.EnableTracing = true
 	grpc_logrus.ReplaceGrpcLogger(logger)
 
-	// gRPC proxy logic.
-	backendConn := dialBackendOrFail()
+	// gRPC proxy logic. proxyRouter picks the backend per call (by method/authority/tenant header
+	// when --director_config_file is set, otherwise always the single default backend), so the
+	// director stays a thin wrapper around it that also forwards the verified client cert identity.
+	proxyRouter := newRouter(dialBackendOrFail())
 	director := func(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
+		// proxyRouter.Direct records the resolved route/backend/namespace on ctx's *routeInfo (set
+		// up by routeMetricsStreamInterceptor) as a side effect, may attach a rule's LeaseTTL as a
+		// deadline to the context it returns, and may fail the call outright (an OrderedBy
+		// violation), before telling us which conn to use.
+		routedCtx, conn, err := proxyRouter.Direct(ctx, fullMethodName)
+		if err != nil {
+			return ctx, nil, err
+		}
+
 		md, _ := metadata.FromIncomingContext(ctx)
-		outCtx, _ := context.WithCancel(ctx)
+		outCtx, _ := context.WithCancel(routedCtx)
 		mdCopy := md.Copy()
 		delete(mdCopy, "user-agent")
+		// A caller-supplied x-forwarded-client-cert or x-grpc-namespace must not ride along next to
+		// the ones we attach ourselves below, or a client could impersonate a peer cert identity or
+		// namespace it never actually had (metadata.Join appends rather than overwrites).
+		delete(mdCopy, "x-forwarded-client-cert")
+		delete(mdCopy, "x-grpc-namespace")
+		if clientCertMd := forwardedClientCertMetadata(ctx); clientCertMd != nil {
+			mdCopy = metadata.Join(mdCopy, clientCertMd)
+		}
+		if info := routeInfoFromContext(ctx); info != nil && info.namespace != "" {
+			mdCopy = metadata.Join(mdCopy, metadata.Pairs("x-grpc-namespace", info.namespace))
+		}
 		outCtx = metadata.NewOutgoingContext(outCtx, mdCopy)
-		return outCtx, backendConn, nil
+		return outCtx, conn, nil
 	}
-	// Server with logging and monitoring enabled.
+
+	// authInterceptors is nil unless at least one --auth_* flag is set, in which case it runs
+	// ahead of the director (see chains below) so an unauthenticated/unauthorized call never
+	// reaches proxyRouter.Direct, and its verified identity rides along in the outgoing metadata
+	// the director above builds from the incoming context.
+	authInterceptors := buildAuthInterceptorsOrFail()
+	unaryChain := []grpc.UnaryServerInterceptor{grpc_logrus.UnaryServerInterceptor(logger)}
+	streamChain := []grpc.StreamServerInterceptor{grpc_logrus.StreamServerInterceptor(logger)}
+	if authInterceptors != nil {
+		unaryChain = append(unaryChain, authInterceptors.UnaryServerInterceptor)
+		streamChain = append(streamChain, authInterceptors.StreamServerInterceptor)
+	}
+	unaryChain = append(unaryChain, grpc_prometheus.UnaryServerInterceptor, routeMetricsUnaryInterceptor)
+	streamChain = append(streamChain, grpc_prometheus.StreamServerInterceptor, routeMetricsStreamInterceptor)
+
+	// Server with logging, auth, and monitoring enabled.
 	return grpc.NewServer(
 		grpc.CustomCodec(proxy.Codec()), // needed for proxy to function.
 		grpc.UnknownServiceHandler(proxy.TransparentHandler(director)),
 		grpc.MaxRecvMsgSize(*flagMaxCallRecvMsgSize),
-		grpc_middleware.WithUnaryServerChain(
-			grpc_logrus.UnaryServerInterceptor(logger),
-			grpc_prometheus.UnaryServerInterceptor,
-		),
-		grpc_middleware.WithStreamServerChain(
-			grpc_logrus.StreamServerInterceptor(logger),
-			grpc_prometheus.StreamServerInterceptor,
-		),
+		grpc_middleware.WithUnaryServerChain(unaryChain...),
+		grpc_middleware.WithStreamServerChain(streamChain...),
 	)
 }
 
@@ -250,47 +283,71 @@ func buildListenerOrFail(name string, port int) net.Listener {
 	)
 }
 
+// makeHttpOriginFunc and makeWebsocketOriginFunc read *allowedOrigins on every call (rather than
+// branching on the allow-all flag once at startup) so that a hot reload of either the allow-all
+// toggle or the origin whitelist takes effect for every request that arrives after it, without
+// rebuilding the wrapper.
 func makeHttpOriginFunc(allowedOrigins *allowedOrigins) func(origin string) bool {
-	if *flagAllowAllOrigins {
-		return func(origin string) bool {
-			return true
-		}
-	}
 	return allowedOrigins.IsAllowed
 }
 
 func makeWebsocketOriginFunc(allowedOrigins *allowedOrigins) func(req *http.Request) bool {
-	if *flagAllowAllOrigins {
-		return func(req *http.Request) bool {
-			return true
-		}
-	} else {
-		return func(req *http.Request) bool {
-			origin, err := grpcweb.WebsocketRequestOrigin(req)
-			if err != nil {
-				grpclog.Warning(err)
-				return false
-			}
-			return allowedOrigins.IsAllowed(origin)
+	return func(req *http.Request) bool {
+		origin, err := grpcweb.WebsocketRequestOrigin(req)
+		if err != nil {
+			grpclog.Warning(err)
+			return false
 		}
+		return allowedOrigins.IsAllowed(origin)
 	}
 }
 
 func makeAllowedOrigins(origins []string) *allowedOrigins {
-	o := map[string]struct{}{}
-	for _, allowedOrigin := range origins {
-		o[allowedOrigin] = struct{}{}
-	}
-	return &allowedOrigins{
-		origins: o,
-	}
+	a := &allowedOrigins{}
+	a.Set(*flagAllowAllOrigins, origins)
+	return a
 }
 
 type allowedOrigins struct {
-	origins map[string]struct{}
+	mu       sync.RWMutex
+	allowAll bool
+	origins  map[string]struct{}
 }
 
 func (a *allowedOrigins) IsAllowed(origin string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.allowAll {
+		return true
+	}
 	_, ok := a.origins[origin]
 	return ok
 }
+
+// Get returns a point-in-time snapshot of the allow-all toggle and whitelisted origins. This is
+// what buildSettings/applySettingsUpdate read and diff against instead of the unguarded
+// *flagAllowAllOrigins/*flagAllowedOrigins globals, so a GET racing a PUT on /settings can no
+// longer observe a torn read.
+func (a *allowedOrigins) Get() (bool, []string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	origins := make([]string, 0, len(a.origins))
+	for origin := range a.origins {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return a.allowAll, origins
+}
+
+// Set replaces the allow-all toggle and whitelisted origins in place, so a hot-reloaded
+// configuration takes effect immediately without rebuilding the gRPC-Web wrapper.
+func (a *allowedOrigins) Set(allowAll bool, origins []string) {
+	o := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		o[origin] = struct{}{}
+	}
+	a.mu.Lock()
+	a.allowAll = allowAll
+	a.origins = o
+	a.mu.Unlock()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/soheilhy/cmux"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+)
+
+var (
+	flagAdminApiToken = pflag.String("admin_api_token", "", "bearer token required on the Authorization header to read or write /settings. Empty disables auth (only safe behind a trusted-proxies-restricted or mTLS-only admin listener).")
+
+	flagShutdownDrainTimeout = pflag.Duration("shutdown_drain_timeout", 30*time.Second, "on SIGINT/SIGTERM, how long in-flight HTTP requests and gRPC streams get to finish before the server is force-closed.")
+)
+
+// proxyListeners are the sockets opened once at startup and kept bound for the life of the
+// process; Run() serves them and, on reload, swaps what's behind them in place rather than
+// reopening the listeners themselves.
+type proxyListeners struct {
+	http  net.Listener
+	tls   net.Listener
+	admin net.Listener
+}
+
+// Listen opens every configured listener up front, mirroring argo-cd's split of Listen() from
+// Run() so a future reload never has to re-bind a port.
+func Listen() *proxyListeners {
+	pl := &proxyListeners{}
+	if *runHttpServer {
+		pl.http = buildListenerOrFail("http", *flagHttpPort)
+	}
+	if *runTlsServer {
+		pl.tls = buildListenerOrFail("http_tls", *flagHttpTlsPort)
+	}
+	if *flagAdminPort != 0 {
+		pl.admin = buildAdminListenerOrFail()
+	}
+	return pl
+}
+
+// Run builds the gRPC proxy and serves it on the already-open listeners. Configuration that can
+// change without dropping connections - the server TLS certificate (server_tls.go) and the
+// allowed-origins whitelist (liveAllowedOrigins) - is read fresh on every handshake/request, the
+// certificate from an atomic.Value and the origins from a mutex-guarded set, so a SIGHUP-triggered reload or a /settings PUT takes effect
+// immediately with no listener churn. A SIGINT/SIGTERM instead drains and stops the servers,
+// giving in-flight work up to --shutdown_drain_timeout to complete.
+func Run(ctx context.Context, listeners *proxyListeners, logger *logrus.Entry, errChan chan error) {
+	grpcServer, wrappedGrpc := buildProxyAndWrapper(logger)
+
+	var servers []*http.Server
+	var mu sync.Mutex
+	register := func(s *http.Server) {
+		mu.Lock()
+		servers = append(servers, s)
+		mu.Unlock()
+	}
+
+	if listeners.http != nil {
+		httpServer := buildServer(wrappedGrpc)
+		register(httpServer)
+		serveServer(httpServer, listeners.http, "http", errChan)
+	}
+
+	if listeners.tls != nil {
+		tlsListener := tls.NewListener(listeners.tls, buildServerTlsOrFail())
+		mux := cmux.New(tlsListener)
+		grpcListener := mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+		webListener := mux.Match(cmux.Any())
+
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				errChan <- fmt.Errorf("http_tls grpc server error: %v", err)
+			}
+		}()
+		webServer := buildServer(wrappedGrpc)
+		register(webServer)
+		serveServer(webServer, webListener, "http_tls_web", errChan)
+		go func() {
+			if err := mux.Serve(); err != nil {
+				errChan <- fmt.Errorf("http_tls cmux error: %v", err)
+			}
+		}()
+	}
+
+	if listeners.admin != nil {
+		adminServer := buildAdminServer()
+		register(adminServer)
+		serveServer(adminServer, listeners.admin, "admin", errChan)
+	}
+
+	go watchReloadSignal(logger)
+	go gracefulShutdownOnSignal(grpcServer, servers, logger)
+}
+
+func watchReloadSignal(logger *logrus.Entry) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		logger.Info("received SIGHUP, reloading server TLS certificate")
+		if err := reloadServerCertOrFail(); err != nil {
+			logger.Errorf("SIGHUP reload failed: %v", err)
+		}
+	}
+}
+
+func gracefulShutdownOnSignal(grpcServer *grpc.Server, servers []*http.Server, logger *logrus.Entry) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	logger.Infof("shutting down, draining for up to %v", *flagShutdownDrainTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), *flagShutdownDrainTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-drainCtx.Done():
+		grpcServer.Stop()
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			s.Shutdown(drainCtx)
+		}(s)
+	}
+	wg.Wait()
+	os.Exit(0)
+}
+
+// settingsRequest is the subset of Settings an operator is allowed to change at runtime via
+// PUT /settings. The server-facing TLS certificate also hot-reloads, but through
+// watchServerCertFiles/SIGHUP (server_tls.go) rather than through this endpoint. Backend address
+// and HTTP timeouts are NOT covered here: the default backend connection and the already-running
+// http.Server(s) both bake these in at dial/construction time, and there's no handle on either one
+// to redial or reconfigure in place - changing them still requires a restart.
+type settingsRequest struct {
+	AllowAllOrigins *bool    `json:"allow_all_origins,omitempty"`
+	AllowedOrigins  []string `json:"allowed_origins,omitempty"`
+}
+
+// settingsHandler serves GET (today's leakSettings behaviour) and PUT (live configuration
+// changes) on /settings, guarded by the --admin_api_token bearer token when one is configured.
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdminRequest(r) {
+		http.Error(w, "missing or invalid Authorization bearer token", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		leakSettings(w, r)
+	case http.MethodPut:
+		applySettingsUpdate(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func authorizeAdminRequest(r *http.Request) bool {
+	if *flagAdminApiToken == "" {
+		return true
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") == *flagAdminApiToken
+}
+
+func applySettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	var update settingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	currentAllowAll, currentOrigins := liveAllowedOrigins.Get()
+	allowAll := currentAllowAll
+	if update.AllowAllOrigins != nil {
+		allowAll = *update.AllowAllOrigins
+	}
+	origins := currentOrigins
+	if update.AllowedOrigins != nil {
+		origins = update.AllowedOrigins
+	}
+	if allowAll && len(origins) != 0 {
+		http.Error(w, "ambiguous update: allow_all_origins=true together with a non-empty allowed_origins", http.StatusConflict)
+		return
+	}
+
+	// liveAllowedOrigins is the sole source of truth for these two settings from here on;
+	// *flagAllowAllOrigins/*flagAllowedOrigins are left untouched post-startup so a GET racing
+	// this PUT on another goroutine only ever reads through the mutex-guarded copy above,
+	// never a bare pointer write.
+	liveAllowedOrigins.Set(allowAll, origins)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildSettings())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAuthorizeAdminRequest(t *testing.T) {
+	orig := flagAdminApiToken
+	defer func() { flagAdminApiToken = orig }()
+
+	token := "s3cr3t"
+	flagAdminApiToken = &token
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	if authorizeAdminRequest(req) {
+		t.Errorf("authorizeAdminRequest() with no Authorization header should have failed")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	if !authorizeAdminRequest(req) {
+		t.Errorf("authorizeAdminRequest() with the correct bearer token should have succeeded")
+	}
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	if authorizeAdminRequest(req) {
+		t.Errorf("authorizeAdminRequest() with the wrong bearer token should have failed")
+	}
+
+	empty := ""
+	flagAdminApiToken = &empty
+	req.Header.Del("Authorization")
+	if !authorizeAdminRequest(req) {
+		t.Errorf("authorizeAdminRequest() with no token configured should allow any request")
+	}
+}
+
+func TestSettingsHandlerDispatch(t *testing.T) {
+	liveAllowedOrigins = makeAllowedOrigins(nil)
+
+	getRec := httptest.NewRecorder()
+	settingsHandler(getRec, httptest.NewRequest(http.MethodGet, "/settings", nil))
+	if getRec.Code != http.StatusOK {
+		t.Errorf("GET /settings = %v, want %v", getRec.Code, http.StatusOK)
+	}
+
+	putRec := httptest.NewRecorder()
+	putReq := httptest.NewRequest(http.MethodPut, "/settings", bytes.NewBufferString(`{"allow_all_origins":true}`))
+	settingsHandler(putRec, putReq)
+	if putRec.Code != http.StatusOK {
+		t.Errorf("PUT /settings = %v, want %v", putRec.Code, http.StatusOK)
+	}
+	if allowAll, _ := liveAllowedOrigins.Get(); !allowAll {
+		t.Errorf("PUT /settings didn't apply allow_all_origins=true")
+	}
+
+	deleteRec := httptest.NewRecorder()
+	settingsHandler(deleteRec, httptest.NewRequest(http.MethodDelete, "/settings", nil))
+	if deleteRec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /settings = %v, want %v", deleteRec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSettingsHandlerRequiresBearerToken(t *testing.T) {
+	orig := flagAdminApiToken
+	defer func() { flagAdminApiToken = orig }()
+	token := "s3cr3t"
+	flagAdminApiToken = &token
+
+	liveAllowedOrigins = makeAllowedOrigins(nil)
+
+	rec := httptest.NewRecorder()
+	settingsHandler(rec, httptest.NewRequest(http.MethodGet, "/settings", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /settings with no bearer token = %v, want %v", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	settingsHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /settings with the correct bearer token = %v, want %v", rec.Code, http.StatusOK)
+	}
+}
+
+func TestApplySettingsUpdateRejectsAmbiguousUpdate(t *testing.T) {
+	liveAllowedOrigins = makeAllowedOrigins(nil)
+
+	rec := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"allow_all_origins":true,"allowed_origins":["https://example.com"]}`)
+	applySettingsUpdate(rec, httptest.NewRequest(http.MethodPut, "/settings", body))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("applySettingsUpdate() with allow_all_origins and a non-empty allowed_origins = %v, want %v", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestApplySettingsUpdatePreservesFieldsOmittedFromTheRequest(t *testing.T) {
+	liveAllowedOrigins = makeAllowedOrigins([]string{"https://example.com"})
+
+	rec := httptest.NewRecorder()
+	body := bytes.NewBufferString(`{"allow_all_origins":false}`)
+	applySettingsUpdate(rec, httptest.NewRequest(http.MethodPut, "/settings", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("applySettingsUpdate() = %v, want %v", rec.Code, http.StatusOK)
+	}
+	if _, origins := liveAllowedOrigins.Get(); len(origins) != 1 || origins[0] != "https://example.com" {
+		t.Errorf("applySettingsUpdate() with allowed_origins omitted should have left it untouched, got %v", origins)
+	}
+}
+
+// TestSettingsHandlerConcurrentAccess exercises the GET/PUT race the review flagged: a GET
+// reading the Settings snapshot concurrently with PUTs mutating it. This only actually proves
+// anything under `go test -race`, but passes either way so it stays in the default `go test ./...` run.
+func TestSettingsHandlerConcurrentAccess(t *testing.T) {
+	liveAllowedOrigins = makeAllowedOrigins(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			settingsHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/settings", nil))
+		}()
+		go func(i int) {
+			defer wg.Done()
+			body := bytes.NewBufferString(fmt.Sprintf(`{"allowed_origins":["https://example-%d.com"]}`, i))
+			settingsHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/settings", body))
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mwitkow/grpc-proxy/proxy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	flagDirectorConfigFile = pflag.String("director_config_file", "", "Path to a YAML or JSON file describing routing rules and named backends. When unset, every call goes to the single --backend_addr as before.")
+)
+
+// routeRule matches an incoming call against fullMethodName, :authority, or a request header, and
+// sends it to a named backend from the pool. Rules are evaluated in file order; the first match wins.
+type routeRule struct {
+	Name               string `json:"name" yaml:"name"`
+	MethodPattern      string `json:"method_pattern,omitempty" yaml:"method_pattern,omitempty"`
+	AuthorityPattern   string `json:"authority_pattern,omitempty" yaml:"authority_pattern,omitempty"`
+	HeaderName         string `json:"header_name,omitempty" yaml:"header_name,omitempty"`
+	HeaderValuePattern string `json:"header_value_pattern,omitempty" yaml:"header_value_pattern,omitempty"`
+	Backend            string `json:"backend" yaml:"backend"`
+
+	// NamespacePrefix, if set, is stripped from fullMethodName before MethodPattern/AuthorityPattern
+	// are evaluated, clientv3-style: several tenants sharing the same service can be matched by one
+	// MethodPattern once their own namespace prefix is gone. router.Direct records the stripped
+	// prefix on the call's *routeInfo, and the director forwards it to the backend as the
+	// x-grpc-namespace header.
+	NamespacePrefix string `json:"namespace_prefix,omitempty" yaml:"namespace_prefix,omitempty"`
+
+	// LeaseTTL, if set, bounds how long a call routed by this rule may stay open; router.Direct
+	// attaches a deadline to the call's context, mirroring clientv3's lease-bound streaming watches.
+	LeaseTTL time.Duration `json:"lease_ttl,omitempty" yaml:"lease_ttl,omitempty"`
+
+	// OrderedBy, if set, names the metadata header each call on this rule must present a strictly
+	// increasing integer value in (scoped per caller, via the authenticated-user header or peer
+	// address), same as clientv3's ordering wrapper rejects stale revisions from a watch stream.
+	OrderedBy string `json:"ordered_by,omitempty" yaml:"ordered_by,omitempty"`
+
+	methodRe    *regexp.Regexp
+	authorityRe *regexp.Regexp
+	headerRe    *regexp.Regexp
+}
+
+// backendConfig describes one named backend in the pool, each dialed with its own TLS, keepalive,
+// max-recv-size and backoff, so a single proxy instance can front several backends.
+type backendConfig struct {
+	Name              string        `json:"name" yaml:"name"`
+	Address           string        `json:"address" yaml:"address"`
+	TLS               bool          `json:"tls,omitempty" yaml:"tls,omitempty"`
+	TLSCaFile         string        `json:"tls_ca_file,omitempty" yaml:"tls_ca_file,omitempty"`
+	KeepAliveInterval time.Duration `json:"keep_alive_interval,omitempty" yaml:"keep_alive_interval,omitempty"`
+	MaxRecvMsgSize    int           `json:"max_recv_msg_size_bytes,omitempty" yaml:"max_recv_msg_size_bytes,omitempty"`
+	BackoffMaxDelay   time.Duration `json:"backoff_max_delay,omitempty" yaml:"backoff_max_delay,omitempty"`
+}
+
+type routerConfig struct {
+	Backends []backendConfig `json:"backends" yaml:"backends"`
+	Rules    []routeRule     `json:"rules" yaml:"rules"`
+}
+
+var routedRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "grpcwebproxy_routed_requests_total",
+		Help: "Count of proxied calls by the route rule and backend that handled them.",
+	},
+	[]string{"route", "backend"},
+)
+
+func init() {
+	prometheus.MustRegister(routedRequestsTotal)
+}
+
+// router holds the live routing table and backend connection pool. Config is (re)loaded from
+// --director_config_file at startup and on every fsnotify write to that file, with the new table
+// and pool swapped in under the lock. The replaced pool's connections are then closed, so calls
+// still in flight on them fail (see reload).
+type router struct {
+	mu          sync.RWMutex
+	rules       []routeRule
+	pool        map[string]*grpc.ClientConn
+	defaultConn *grpc.ClientConn
+
+	ordering *orderingTracker
+}
+
+func newRouter(defaultConn *grpc.ClientConn) *router {
+	r := &router{defaultConn: defaultConn, ordering: newOrderingTracker()}
+	if *flagDirectorConfigFile != "" {
+		if err := r.reload(); err != nil {
+			logrus.Fatalf("failed loading --director_config_file: %v", err)
+		}
+		go r.watch()
+	}
+	return r
+}
+
+func (r *router) reload() error {
+	raw, err := ioutil.ReadFile(*flagDirectorConfigFile)
+	if err != nil {
+		return err
+	}
+	var cfg routerConfig
+	if strings.HasSuffix(*flagDirectorConfigFile, ".yaml") || strings.HasSuffix(*flagDirectorConfigFile, ".yml") {
+		err = yaml.Unmarshal(raw, &cfg)
+	} else {
+		err = json.Unmarshal(raw, &cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed parsing director config: %v", err)
+	}
+
+	pool := make(map[string]*grpc.ClientConn, len(cfg.Backends))
+	for _, backend := range cfg.Backends {
+		conn, err := dialPooledBackendOrFail(backend)
+		if err != nil {
+			return fmt.Errorf("failed dialing backend %q: %v", backend.Name, err)
+		}
+		pool[backend.Name] = conn
+	}
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %v", i, err)
+		}
+		if _, ok := pool[cfg.Rules[i].Backend]; !ok {
+			return fmt.Errorf("rule %d references unknown backend %q", i, cfg.Rules[i].Backend)
+		}
+	}
+
+	r.mu.Lock()
+	r.rules = cfg.Rules
+	oldPool := r.pool
+	r.pool = pool
+	r.mu.Unlock()
+	logrus.Infof("director: loaded %d rule(s) across %d backend(s) from %v", len(cfg.Rules), len(pool), *flagDirectorConfigFile)
+
+	// Close the replaced connections so the pool we just swapped out doesn't leak a full set of
+	// backend connections (and their keepalive goroutines) on every reload. Any call still in
+	// flight against one of these ends with a transport-closed error, same as a backend restart.
+	for name, conn := range oldPool {
+		if err := conn.Close(); err != nil {
+			logrus.Warnf("director: error closing replaced connection to backend %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *router) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.Warnf("director config hot-reload disabled, failed creating fsnotify watcher: %v", err)
+		return
+	}
+	if err := watcher.Add(*flagDirectorConfigFile); err != nil {
+		logrus.Warnf("director config hot-reload disabled: %v", err)
+		return
+	}
+	for event := range watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			logrus.Errorf("failed reloading director config after change to %v: %v", event.Name, err)
+		}
+	}
+}
+
+func (rule *routeRule) compile() (err error) {
+	if rule.MethodPattern != "" {
+		if rule.methodRe, err = regexp.Compile(rule.MethodPattern); err != nil {
+			return err
+		}
+	}
+	if rule.AuthorityPattern != "" {
+		if rule.authorityRe, err = regexp.Compile(rule.AuthorityPattern); err != nil {
+			return err
+		}
+	}
+	if rule.HeaderValuePattern != "" {
+		if rule.headerRe, err = regexp.Compile(rule.HeaderValuePattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rule *routeRule) matches(fullMethodName string, md metadata.MD) bool {
+	if rule.NamespacePrefix != "" {
+		if !strings.HasPrefix(fullMethodName, rule.NamespacePrefix) {
+			return false
+		}
+		fullMethodName = strings.TrimPrefix(fullMethodName, rule.NamespacePrefix)
+	}
+	if rule.methodRe != nil && !rule.methodRe.MatchString(fullMethodName) {
+		return false
+	}
+	if rule.authorityRe != nil && !rule.authorityRe.MatchString(firstOrEmpty(md.Get(":authority"))) {
+		return false
+	}
+	if rule.HeaderName != "" {
+		values := md.Get(rule.HeaderName)
+		if len(values) == 0 {
+			return false
+		}
+		if rule.headerRe != nil && !rule.headerRe.MatchString(values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Direct picks the backend connection for fullMethodName, evaluating rules in order and falling
+// back to the single default backend when no rule matches or no router config was loaded. The
+// resolved rule name, backend, and namespace (if any) are recorded on ctx's *routeInfo, if present,
+// so the metrics interceptors and the director's metadata forwarding can use them. A matching rule's
+// LeaseTTL, if set, is applied to the returned context, and its OrderedBy check, if set, can fail
+// the call outright.
+func (r *router) Direct(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	r.mu.RLock()
+	rules, pool := r.rules, r.pool
+	r.mu.RUnlock()
+
+	for _, rule := range rules {
+		if !rule.matches(fullMethodName, md) {
+			continue
+		}
+		conn := pool[rule.Backend]
+		if info := routeInfoFromContext(ctx); info != nil {
+			info.route, info.backend, info.namespace = rule.Name, rule.Backend, rule.NamespacePrefix
+		}
+		if rule.OrderedBy != "" {
+			if err := r.ordering.check(rule.Name, callerID(ctx, md), md.Get(rule.OrderedBy)); err != nil {
+				return ctx, nil, err
+			}
+		}
+		if rule.LeaseTTL != 0 {
+			leasedCtx, _ := context.WithTimeout(ctx, rule.LeaseTTL)
+			ctx = leasedCtx
+		}
+		return ctx, conn, nil
+	}
+	if info := routeInfoFromContext(ctx); info != nil {
+		info.route, info.backend = "default", "default"
+	}
+	return ctx, r.defaultConn, nil
+}
+
+// callerID identifies the caller an OrderedBy sequence is tracked per, preferring the identity auth
+// interceptors attach (see go/grpcweb/auth.MetadataAuthenticatedUser) and falling back to the peer
+// address so ordering still works when no auth is configured.
+func callerID(ctx context.Context, md metadata.MD) string {
+	if values := md.Get("authenticated-user"); len(values) != 0 && values[0] != "" {
+		return values[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// orderingTracker enforces that, per (rule, caller) pair, a monotonically increasing integer
+// sequence value is presented on every call, the streaming-RPC equivalent of clientv3's ordering
+// wrapper rejecting a watch response with a revision older than one already delivered.
+type orderingTracker struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+func newOrderingTracker() *orderingTracker {
+	return &orderingTracker{last: map[string]int64{}}
+}
+
+func (o *orderingTracker) check(rule, caller string, values []string) error {
+	if len(values) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "rule %q requires an ordering sequence header", rule)
+	}
+	seq, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "rule %q: invalid ordering sequence %q", rule, values[0])
+	}
+	key := rule + "/" + caller
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if last, ok := o.last[key]; ok && seq <= last {
+		return status.Errorf(codes.FailedPrecondition, "rule %q: out-of-order call, sequence %d did not advance past %d", rule, seq, last)
+	}
+	o.last[key] = seq
+	return nil
+}
+
+func dialPooledBackendOrFail(cfg backendConfig) (*grpc.ClientConn, error) {
+	dialOptions := []grpc.DialOption{
+		grpc.WithCodec(proxy.Codec()),
+	}
+	if cfg.BackoffMaxDelay != 0 {
+		dialOptions = append(dialOptions, grpc.WithBackoffMaxDelay(cfg.BackoffMaxDelay))
+	}
+	if cfg.MaxRecvMsgSize != 0 {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSize)))
+	}
+	if cfg.KeepAliveInterval != 0 {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time: cfg.KeepAliveInterval,
+		}))
+	}
+	if cfg.TLS {
+		tlsConfig := &tls.Config{}
+		if cfg.TLSCaFile != "" {
+			tlsConfig.RootCAs = loadCertPoolOrFail([]string{cfg.TLSCaFile})
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+	return grpc.Dial(cfg.Address, dialOptions...)
+}
+
+// routeInfo carries the resolved rule/backend names from router.Direct (called deep inside the
+// proxy handler) back out to the metrics interceptors wrapping that handler.
+type routeInfo struct {
+	route     string
+	backend   string
+	namespace string
+}
+
+type routeInfoKey struct{}
+
+func contextWithRouteInfo(ctx context.Context) (context.Context, *routeInfo) {
+	info := &routeInfo{}
+	return context.WithValue(ctx, routeInfoKey{}, info), info
+}
+
+func routeInfoFromContext(ctx context.Context) *routeInfo {
+	info, _ := ctx.Value(routeInfoKey{}).(*routeInfo)
+	return info
+}
+
+// routeMetricsUnaryInterceptor counts the call in grpcwebproxy_routed_requests_total, labelled with
+// the route rule and backend router.Direct resolved for it. grpc_prometheus' own metrics carry no
+// route or backend label.
+func routeMetricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, route := contextWithRouteInfo(ctx)
+	resp, err := handler(ctx, req)
+	routedRequestsTotal.WithLabelValues(route.route, route.backend).Inc()
+	return resp, err
+}
+
+// routeMetricsStreamInterceptor is the streaming counterpart of routeMetricsUnaryInterceptor.
+func routeMetricsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, route := contextWithRouteInfo(ss.Context())
+	err := handler(srv, &routeInfoServerStream{ServerStream: ss, ctx: ctx})
+	routedRequestsTotal.WithLabelValues(route.route, route.backend).Inc()
+	return err
+}
+
+type routeInfoServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *routeInfoServerStream) Context() context.Context {
+	return s.ctx
+}
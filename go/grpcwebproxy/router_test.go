@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRouteRuleCompile(t *testing.T) {
+	rule := routeRule{MethodPattern: "^/foo\\.Service/", AuthorityPattern: "^tenant-", HeaderValuePattern: "^v[0-9]+$"}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile() returned an error for valid patterns: %v", err)
+	}
+	if rule.methodRe == nil || rule.authorityRe == nil || rule.headerRe == nil {
+		t.Fatalf("compile() left a pattern uncompiled: %+v", rule)
+	}
+
+	bad := routeRule{MethodPattern: "("}
+	if err := bad.compile(); err == nil {
+		t.Fatalf("compile() with an invalid regexp should have returned an error")
+	}
+}
+
+func TestRouteRuleMatches(t *testing.T) {
+	mustCompile := func(rule routeRule) *routeRule {
+		if err := rule.compile(); err != nil {
+			t.Fatalf("compile() failed: %v", err)
+		}
+		return &rule
+	}
+
+	tests := []struct {
+		name           string
+		rule           *routeRule
+		fullMethodName string
+		md             metadata.MD
+		want           bool
+	}{
+		{
+			name:           "method pattern matches",
+			rule:           mustCompile(routeRule{MethodPattern: "^/foo\\.Service/"}),
+			fullMethodName: "/foo.Service/Get",
+			want:           true,
+		},
+		{
+			name:           "method pattern doesn't match",
+			rule:           mustCompile(routeRule{MethodPattern: "^/foo\\.Service/"}),
+			fullMethodName: "/bar.Service/Get",
+			want:           false,
+		},
+		{
+			name:           "namespace prefix stripped before matching",
+			rule:           mustCompile(routeRule{NamespacePrefix: "/tenant1", MethodPattern: "^/foo\\.Service/"}),
+			fullMethodName: "/tenant1/foo.Service/Get",
+			want:           true,
+		},
+		{
+			name:           "namespace prefix missing fails the rule",
+			rule:           mustCompile(routeRule{NamespacePrefix: "/tenant1", MethodPattern: "^/foo\\.Service/"}),
+			fullMethodName: "/foo.Service/Get",
+			want:           false,
+		},
+		{
+			name:           "authority pattern matches incoming :authority",
+			rule:           mustCompile(routeRule{AuthorityPattern: "^tenant-a"}),
+			fullMethodName: "/foo.Service/Get",
+			md:             metadata.Pairs(":authority", "tenant-a.example.com"),
+			want:           true,
+		},
+		{
+			name:           "header required but absent",
+			rule:           mustCompile(routeRule{HeaderName: "x-tenant"}),
+			fullMethodName: "/foo.Service/Get",
+			want:           false,
+		},
+		{
+			name:           "header present and value pattern matches",
+			rule:           mustCompile(routeRule{HeaderName: "x-tenant", HeaderValuePattern: "^a$"}),
+			fullMethodName: "/foo.Service/Get",
+			md:             metadata.Pairs("x-tenant", "a"),
+			want:           true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.fullMethodName, tt.md); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// dialNoop returns a *grpc.ClientConn that never actually connects (grpc.Dial is non-blocking
+// without grpc.WithBlock()), just enough for Direct() to hand back a distinguishable pointer.
+func dialNoop(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial("127.0.0.1:0", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpc.Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestRouterDirect(t *testing.T) {
+	matched := dialNoop(t)
+	fallback := dialNoop(t)
+
+	rule := routeRule{Name: "rule-a", MethodPattern: "^/foo\\.Service/", Backend: "a"}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile() failed: %v", err)
+	}
+	r := &router{
+		rules:       []routeRule{rule},
+		pool:        map[string]*grpc.ClientConn{"a": matched},
+		defaultConn: fallback,
+		ordering:    newOrderingTracker(),
+	}
+
+	_, conn, err := r.Direct(context.Background(), "/foo.Service/Get")
+	if err != nil {
+		t.Fatalf("Direct() returned an error: %v", err)
+	}
+	if conn != matched {
+		t.Errorf("Direct() returned the wrong connection for a matching rule")
+	}
+
+	_, conn, err = r.Direct(context.Background(), "/other.Service/Get")
+	if err != nil {
+		t.Fatalf("Direct() returned an error: %v", err)
+	}
+	if conn != fallback {
+		t.Errorf("Direct() didn't fall back to the default backend for an unmatched call")
+	}
+}
+
+func TestRouterDirectAppliesLeaseTTL(t *testing.T) {
+	rule := routeRule{Name: "leased", MethodPattern: "^/foo\\.Service/", Backend: "a", LeaseTTL: time.Minute}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile() failed: %v", err)
+	}
+	r := &router{
+		rules:       []routeRule{rule},
+		pool:        map[string]*grpc.ClientConn{"a": dialNoop(t)},
+		defaultConn: dialNoop(t),
+		ordering:    newOrderingTracker(),
+	}
+
+	ctx, _, err := r.Direct(context.Background(), "/foo.Service/Get")
+	if err != nil {
+		t.Fatalf("Direct() returned an error: %v", err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("Direct() didn't attach a deadline for a rule with LeaseTTL set")
+	}
+}
+
+func TestRouterDirectEnforcesOrdering(t *testing.T) {
+	rule := routeRule{Name: "ordered", MethodPattern: "^/foo\\.Service/", Backend: "a", OrderedBy: "x-sequence"}
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile() failed: %v", err)
+	}
+	r := &router{
+		rules:       []routeRule{rule},
+		pool:        map[string]*grpc.ClientConn{"a": dialNoop(t)},
+		defaultConn: dialNoop(t),
+		ordering:    newOrderingTracker(),
+	}
+
+	ctxWithSeq := func(seq string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-sequence", seq))
+	}
+
+	if _, _, err := r.Direct(ctxWithSeq("1"), "/foo.Service/Get"); err != nil {
+		t.Fatalf("Direct() with an increasing sequence returned an error: %v", err)
+	}
+	if _, _, err := r.Direct(ctxWithSeq("2"), "/foo.Service/Get"); err != nil {
+		t.Fatalf("Direct() with an increasing sequence returned an error: %v", err)
+	}
+	if _, _, err := r.Direct(ctxWithSeq("2"), "/foo.Service/Get"); err == nil {
+		t.Fatalf("Direct() with a non-advancing sequence should have returned an error")
+	}
+	if _, _, err := r.Direct(context.Background(), "/foo.Service/Get"); err == nil {
+		t.Fatalf("Direct() with no sequence header should have returned an error")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	flagTlsServerKey  = pflag.String("server_tls_key_file", "", "Path to the PEM key file used for the server-facing (client to proxy) TLS listener.")
+	flagTlsServerClientCertVerification = pflag.String("server_tls_client_cert_verification", "none", "Client certificate verification policy for the server-facing TLS listener. One of: none, verify_if_given, require_and_verify.")
+	flagTlsServerClientCAFiles          = pflag.StringSlice("server_tls_client_ca_files", nil, "Comma-separated paths to PEM files with client CAs used to verify client certificates, when server_tls_client_cert_verification is not 'none'.")
+)
+
+// currentServerCert holds the *tls.Certificate served by GetCertificate below. It's refreshed by
+// watchServerCertFiles whenever the cert/key on disk change, so a certificate rotation takes effect
+// for new handshakes without rebuilding the tls.Listener or dropping existing connections.
+var currentServerCert atomic.Value
+
+// buildServerTlsOrFail builds the tls.Config used for the client-facing (server-side) TLS listener,
+// including optional mutual TLS enforced via --server_tls_client_cert_verification. The listener's
+// tls.Config is built once and kept for the life of the process; GetCertificate reads the most
+// recently loaded cert/key pair so rotating files on disk doesn't require rebuilding the listener.
+func buildServerTlsOrFail() *tls.Config {
+	if *flagTlsServerCert == "" || *flagTlsServerKey == "" {
+		logrus.Fatalf("flag --server_tls_cert_file and --server_tls_key_file must be set to run the TLS server")
+	}
+	if err := reloadServerCertOrFail(); err != nil {
+		logrus.Fatalf("failed reading TLS server keys: %v", err)
+	}
+	go watchServerCertFiles()
+
+	clientAuth, err := clientCertVerificationFromFlag(*flagTlsServerClientCertVerification)
+	if err != nil {
+		logrus.Fatalf("--server_tls_client_cert_verification: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		ClientAuth: clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return currentServerCert.Load().(*tls.Certificate), nil
+		},
+	}
+	if clientAuth != tls.NoClientCert {
+		if len(*flagTlsServerClientCAFiles) == 0 {
+			logrus.Fatalf("--server_tls_client_ca_files must be set when --server_tls_client_cert_verification is %q", *flagTlsServerClientCertVerification)
+		}
+		tlsConfig.ClientCAs = loadCertPoolOrFail(*flagTlsServerClientCAFiles)
+	}
+	return tlsConfig
+}
+
+func reloadServerCertOrFail() error {
+	cert, err := tls.LoadX509KeyPair(*flagTlsServerCert, *flagTlsServerKey)
+	if err != nil {
+		return err
+	}
+	currentServerCert.Store(&cert)
+	return nil
+}
+
+// watchServerCertFiles rebuilds currentServerCert whenever the configured cert or key file is
+// rewritten on disk, e.g. by a cert-manager sidecar renewing a leaf certificate.
+func watchServerCertFiles() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.Warnf("server TLS hot-reload disabled, failed creating fsnotify watcher: %v", err)
+		return
+	}
+	for _, file := range []string{*flagTlsServerCert, *flagTlsServerKey} {
+		if err := watcher.Add(file); err != nil {
+			logrus.Warnf("server TLS hot-reload disabled for %v: %v", file, err)
+		}
+	}
+	for event := range watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+		if err := reloadServerCertOrFail(); err != nil {
+			logrus.Errorf("failed reloading server TLS cert after change to %v: %v", event.Name, err)
+			continue
+		}
+		logrus.Infof("reloaded server TLS certificate after change to %v", event.Name)
+	}
+}
+
+func clientCertVerificationFromFlag(value string) (tls.ClientAuthType, error) {
+	switch value {
+	case "none", "":
+		return tls.NoClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid client certificate verification value %q, must be one of: none, verify_if_given, require_and_verify", value)
+	}
+}
+
+func loadCertPoolOrFail(files []string) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pemBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			logrus.Fatalf("failed reading CA file %v: %v", file, err)
+		}
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			logrus.Fatalf("failed appending CA certs from %v, is it a valid PEM file?", file)
+		}
+	}
+	return pool
+}
+
+// forwardedClientCertMetadata inspects the verified peer certificate on the incoming connection (set
+// when --server_tls_client_cert_verification is verify_if_given or require_and_verify) and, if present,
+// returns outgoing metadata carrying it so the backend can trust the identity the proxy already verified.
+// Only gRPC-Web calls have this peer information: native gRPC calls on the TLS port are served by
+// grpcServer.Serve over the already-terminated TLS connection without transport credentials, so their
+// peer has no AuthInfo and this returns nil for them.
+func forwardedClientCertMetadata(ctx context.Context) metadata.MD {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := tlsInfo.State.PeerCertificates[0]
+	return metadata.Pairs("x-forwarded-client-cert", leaf.Subject.CommonName)
+}
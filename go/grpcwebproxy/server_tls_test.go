@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestClientCertVerificationFromFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{value: "none", want: tls.NoClientCert},
+		{value: "", want: tls.NoClientCert},
+		{value: "verify_if_given", want: tls.VerifyClientCertIfGiven},
+		{value: "require_and_verify", want: tls.RequireAndVerifyClientCert},
+		{value: "bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := clientCertVerificationFromFlag(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("clientCertVerificationFromFlag(%q): expected an error, got nil", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("clientCertVerificationFromFlag(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("clientCertVerificationFromFlag(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}